@@ -0,0 +1,56 @@
+// Package application wires together the orders API's HTTP server and its
+// dependencies.
+package application
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// App holds the orders API's HTTP server and Redis client.
+type App struct {
+	router http.Handler
+	rdb    *redis.Client
+}
+
+// New constructs an App with a Redis client and its routes loaded.
+func New() *App {
+	app := &App{
+		rdb: redis.NewClient(&redis.Options{}),
+	}
+
+	app.loadRoutes()
+
+	return app
+}
+
+// Start runs the HTTP server until ctx is cancelled, then shuts it down
+// gracefully.
+func (a *App) Start(ctx context.Context) error {
+	server := &http.Server{
+		Addr:    ":3000",
+		Handler: a.router,
+	}
+
+	ch := make(chan error, 1)
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			ch <- fmt.Errorf("failed to start server: %w", err)
+		}
+		close(ch)
+	}()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return server.Shutdown(timeout)
+	}
+}
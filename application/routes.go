@@ -0,0 +1,29 @@
+package application
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/sibelephant/orders-api/handler"
+	"github.com/sibelephant/orders-api/repository/order"
+)
+
+// loadRoutes builds the chi router and assigns it to a.router.
+func (a *App) loadRoutes() {
+	router := chi.NewRouter()
+
+	router.Route("/orders", a.loadOrderRoutes)
+
+	a.router = router
+}
+
+// loadOrderRoutes mounts the order REST handlers under /orders.
+func (a *App) loadOrderRoutes(router chi.Router) {
+	orderHandler := &handler.Order{
+		Repo: &order.RedisRepo{Client: a.rdb},
+	}
+
+	router.Post("/", orderHandler.Create)
+	router.Get("/", orderHandler.List)
+	router.Get("/{id}", orderHandler.GetByID)
+	router.Put("/{id}", orderHandler.UpdateByID)
+	router.Delete("/{id}", orderHandler.DeleteByID)
+}
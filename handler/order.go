@@ -1,27 +1,242 @@
+// Package handler implements the HTTP handlers for the orders API.
 package handler
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sibelephant/orders-api/model"
+	"github.com/sibelephant/orders-api/repository/order"
 )
 
+// Order holds the dependencies for the order HTTP handlers.
 type Order struct {
+	Repo *order.RedisRepo
 }
 
+// Create handles POST /orders, inserting a new order and returning it with its
+// assigned OrderID and CreatedAt.
 func (o *Order) Create(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Create an Order")
+	var body struct {
+		ClientOID  string           `json:"client_oid"`
+		CustomerID string           `json:"customer_id"`
+		LineItems  []model.LineItem `json:"line_items"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	orderID, err := o.Repo.NextOrderID(r.Context())
+	if err != nil {
+		log.Println("failed to allocate order id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	ord := model.Order{
+		OrderID:    orderID,
+		ClientOID:  body.ClientOID,
+		CustomerID: body.CustomerID,
+		LineItems:  body.LineItems,
+		CreatedAt:  &now,
+	}
+
+	if err := o.Repo.Insert(r.Context(), ord); errors.Is(err, order.ErrClientOIDExists) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println("failed to insert order:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(ord)
+	if err != nil {
+		log.Println("failed to marshal order:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
 }
 
+// List handles GET /orders, supporting ?cursor= and ?size= query params.
 func (o *Order) List(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("List all Orders")
+	cursor := uint64(0)
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		var err error
+		cursor, err = strconv.ParseUint(c, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	const defaultSize = 50
+	size := uint64(defaultSize)
+	if s := r.URL.Query().Get("size"); s != "" {
+		var err error
+		size, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := o.Repo.FindAll(r.Context(), order.FindAllPage{
+		Offset: uint(cursor),
+		Size:   uint(size),
+	})
+	if err != nil {
+		log.Println("failed to list orders:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body := struct {
+		Items      []model.Order `json:"items"`
+		NextCursor uint64        `json:"next_cursor"`
+	}{
+		Items:      res.Orders,
+		NextCursor: res.Cursor,
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Println("failed to marshal orders:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
 
+// GetByID handles GET /orders/{id}.
 func (o *Order) GetByID(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("get an order by id")
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ord, err := o.Repo.FindByID(r.Context(), id)
+	if errors.Is(err, order.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("failed to find order:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(ord)
+	if err != nil {
+		log.Println("failed to marshal order:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
+
+// UpdateByID handles PUT /orders/{id}.
 func (o *Order) UpdateByID(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("update an order by id")
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	existing, err := o.Repo.FindByID(r.Context(), id)
+	if errors.Is(err, order.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("failed to find order:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var ord model.Order
+	if err := json.NewDecoder(r.Body).Decode(&ord); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ord.OrderID = id
+
+	// Status only changes through MarkFilled/MarkCancelled/PartialFillCancel,
+	// which also move the order between status sets; accepting it here would
+	// let the stored document disagree with whatever set the order is still in.
+	ord.Status = existing.Status
+
+	// ClientOID and CustomerID back the clientoid and user-orders indices, and
+	// CreatedAt is returned to API consumers as the order's original creation
+	// time; a partial PUT body that omits them (e.g. a client only patching
+	// LineItems) must not be treated as clearing them, so carry the stored
+	// values forward unless the caller actually set a replacement.
+	if ord.ClientOID == "" {
+		ord.ClientOID = existing.ClientOID
+	}
+	if ord.CustomerID == "" {
+		ord.CustomerID = existing.CustomerID
+	}
+	if ord.CreatedAt == nil {
+		ord.CreatedAt = existing.CreatedAt
+	}
+
+	updated, err := o.Repo.Update(r.Context(), ord)
+	switch {
+	case errors.Is(err, order.ErrNotExist):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, order.ErrVersionConflict), errors.Is(err, order.ErrClientOIDExists):
+		w.WriteHeader(http.StatusConflict)
+		return
+	case err != nil:
+		log.Println("failed to update order:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		log.Println("failed to marshal order:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
+
+// DeleteByID handles DELETE /orders/{id}.
 func (o *Order) DeleteByID(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("delete an order by id")
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := o.Repo.DeleteByID(r.Context(), id); errors.Is(err, order.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("failed to delete order:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
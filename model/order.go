@@ -0,0 +1,40 @@
+// Package model defines the domain types shared across the order-api services.
+package model
+
+import "time"
+
+// OrderStatus represents where an order is in its lifecycle.
+type OrderStatus string
+
+// Order lifecycle states.
+const (
+	OrderStatusOpen            OrderStatus = "open"
+	OrderStatusPartiallyFilled OrderStatus = "partially_filled"
+	OrderStatusFilled          OrderStatus = "filled"
+	OrderStatusCancelled       OrderStatus = "cancelled"
+)
+
+// Order represents a single customer order.
+type Order struct {
+	OrderID    uint64      `json:"order_id"`
+	ClientOID  string      `json:"client_oid,omitempty"`
+	CustomerID string      `json:"customer_id,omitempty"`
+	Status     OrderStatus `json:"status,omitempty"`
+	FilledQty  uint        `json:"filled_qty,omitempty"`
+	Version    uint64      `json:"version"`
+
+	LineItems []LineItem `json:"line_items"`
+
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	ShippedAt   *time.Time `json:"shipped_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	FilledAt    *time.Time `json:"filled_at,omitempty"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+}
+
+// LineItem represents a single item within an order.
+type LineItem struct {
+	ItemID   uint64 `json:"item_id"`
+	Quantity uint   `json:"quantity"`
+	Price    uint   `json:"price"`
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/sibelephant/orders-api/model"
@@ -21,37 +23,164 @@ func orderIDKey(id uint64) string {
 	return fmt.Sprintf("order: %d", id)
 }
 
-// Insert creates a new order using SetNX and maintains the orders set atomically.
+// clientOIDKey generates the namespaced Redis key mapping a client-supplied
+// order ID to the primary order key.
+func clientOIDKey(clientOID string) string {
+	return fmt.Sprintf("clientoid:%s", clientOID)
+}
+
+// userOrdersKey generates the namespaced Redis key for a customer's sorted
+// set of order keys, scored by creation timestamp.
+func userOrdersKey(customerID string) string {
+	return fmt.Sprintf("user:%s:orders", customerID)
+}
+
+// orderScore returns the sorted-set score for an order, based on its
+// creation timestamp.
+func orderScore(order model.Order) float64 {
+	if order.CreatedAt != nil {
+		return float64(order.CreatedAt.Unix())
+	}
+	return float64(time.Now().Unix())
+}
+
+// statusSetKey returns the Redis set that holds order keys for the given
+// status. Open and partially-filled orders share the open_orders set since
+// neither has reached a terminal state yet.
+func statusSetKey(status model.OrderStatus) string {
+	switch status {
+	case model.OrderStatusFilled:
+		return "filled_orders"
+	case model.OrderStatusCancelled:
+		return "cancelled_orders"
+	default:
+		return "open_orders"
+	}
+}
+
+// NextOrderID atomically allocates the next order ID, for callers that need
+// to assign an OrderID before calling Insert.
+func (r *RedisRepo) NextOrderID(ctx context.Context) (uint64, error) {
+	id, err := r.Client.Incr(ctx, "orders:next_id").Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment next id: %w", err)
+	}
+	return uint64(id), nil
+}
+
+// insertScript creates an order and maintains all of its secondary indices
+// as a single atomic operation. A Redis MULTI/EXEC transaction can't
+// conditionally abort when one queued command's *result* (as opposed to a
+// connection-level error) indicates a conflict - every queued command still
+// runs - so claiming the clientoid mapping has to happen inside the script
+// itself, before anything else is written, to actually reject the insert.
+//
+// KEYS[1] - the order key
+// ARGV[1] - the order JSON
+// ARGV[2] - the clientoid mapping key, or "" if the order has no ClientOID
+// ARGV[3] - the status set to add the order to (e.g. open_orders)
+// ARGV[4] - the user orders sorted set, or "" if the order has no CustomerID
+// ARGV[5] - the sorted-set score to use for ARGV[4]
+var insertScript = redis.NewScript(`
+local orderKey = KEYS[1]
+local clientoidKey = ARGV[2]
+
+if clientoidKey ~= "" and redis.call("EXISTS", clientoidKey) == 1 then
+	return redis.error_reply("CLIENTOIDEXISTS")
+end
+
+if redis.call("SETNX", orderKey, ARGV[1]) == 0 then
+	return redis.error_reply("ALREADYEXISTS")
+end
+
+redis.call("SADD", "orders", orderKey)
+redis.call("SADD", ARGV[3], orderKey)
+
+if clientoidKey ~= "" then
+	redis.call("SET", clientoidKey, orderKey)
+end
+
+if ARGV[4] ~= "" then
+	redis.call("ZADD", ARGV[4], ARGV[5], orderKey)
+end
+
+return "OK"
+`)
+
+// Insert creates a new order and maintains all of its secondary indices
+// atomically: the orders set, its status set, the clientoid:{clientOID}
+// mapping (rejecting the insert if that ClientOID is already in use), and
+// the customer's user:{customerID}:orders sorted set, scored by creation
+// timestamp. New orders default to OrderStatusOpen.
 func (r *RedisRepo) Insert(ctx context.Context, order model.Order) error {
+	if order.Status == "" {
+		order.Status = model.OrderStatusOpen
+	}
+
 	data, err := json.Marshal(order)
 	if err != nil {
 		return fmt.Errorf("failed to encode order : %w", err)
 	}
 
 	key := orderIDKey(order.OrderID)
-	txn := r.Client.TxPipeline()
 
-	res := txn.SetNX(ctx, key, string(data), 0)
-	if err := res.Err(); err != nil {
-		txn.Discard()
-		return fmt.Errorf("failed to set: %w", err)
+	clientOIDTarget := ""
+	if order.ClientOID != "" {
+		clientOIDTarget = clientOIDKey(order.ClientOID)
 	}
 
-	if err := txn.SAdd(ctx, "orders", key).Err(); err != nil {
-		txn.Discard()
-		return fmt.Errorf("failed to add to orders set:%w", err)
+	userSetTarget, score := "", 0.0
+	if order.CustomerID != "" {
+		userSetTarget = userOrdersKey(order.CustomerID)
+		score = orderScore(order)
 	}
 
-	if _, err := txn.Exec(ctx); err != nil {
-		return fmt.Errorf("failed to exec: %w", err)
+	err = insertScript.Run(ctx, r.Client, []string{key},
+		string(data), clientOIDTarget, statusSetKey(order.Status), userSetTarget, score,
+	).Err()
+	switch {
+	case err == nil:
+		return nil
+	case strings.Contains(err.Error(), "CLIENTOIDEXISTS"):
+		return ErrClientOIDExists
+	case strings.Contains(err.Error(), "ALREADYEXISTS"):
+		return fmt.Errorf("failed to set: order %d already exists", order.OrderID)
+	default:
+		return fmt.Errorf("failed to exec insert script: %w", err)
 	}
-
-	return nil
 }
 
 // ErrNotExist is returned when an order doesn't exist.
 var ErrNotExist = errors.New("order does not exist")
 
+// ErrClientOIDExists is returned when an insert is attempted with a ClientOID
+// that already maps to an existing order.
+var ErrClientOIDExists = errors.New("order with client oid already exists")
+
+// FindByClientOID retrieves an order by its client-supplied order ID.
+func (r *RedisRepo) FindByClientOID(ctx context.Context, clientOID string) (model.Order, error) {
+	key, err := r.Client.Get(ctx, clientOIDKey(clientOID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return model.Order{}, ErrNotExist
+	} else if err != nil {
+		return model.Order{}, fmt.Errorf("get client oid mapping: %w", err)
+	}
+
+	value, err := r.Client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return model.Order{}, ErrNotExist
+	} else if err != nil {
+		return model.Order{}, fmt.Errorf("get order: %w", err)
+	}
+
+	var order model.Order
+	if err := json.Unmarshal([]byte(value), &order); err != nil {
+		return model.Order{}, fmt.Errorf("failed to decode order json: %w", err)
+	}
+
+	return order, nil
+}
+
 // FindByID retrieves an order by ID and deserializes from JSON.
 func (r *RedisRepo) FindByID(ctx context.Context, id uint64) (model.Order, error) {
 	key := orderIDKey(id)
@@ -72,55 +201,144 @@ func (r *RedisRepo) FindByID(ctx context.Context, id uint64) (model.Order, error
 	return order, nil
 }
 
-// DeleteByID removes an order and its set membership atomically.
+// DeleteByID removes an order, its set membership, and its clientoid mapping
+// (if any) atomically.
 func (r *RedisRepo) DeleteByID(ctx context.Context, id uint64) error {
 	key := orderIDKey(id)
 
-	// First check if the order exists
-	exists, err := r.Client.Exists(ctx, key).Result()
-	if err != nil {
-		return fmt.Errorf("failed to check order existence: %w", err)
-	}
-	if exists == 0 {
+	value, err := r.Client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
 		return ErrNotExist
+	} else if err != nil {
+		return fmt.Errorf("failed to get order: %w", err)
+	}
+
+	var order model.Order
+	if err := json.Unmarshal([]byte(value), &order); err != nil {
+		return fmt.Errorf("failed to decode order json: %w", err)
 	}
 
 	txn := r.Client.TxPipeline()
 
 	txn.Del(ctx, key)
 	txn.SRem(ctx, "orders", key)
+	txn.SRem(ctx, statusSetKey(order.Status), key)
 
-	_, err = txn.Exec(ctx)
-	if err != nil {
+	if order.ClientOID != "" {
+		txn.Del(ctx, clientOIDKey(order.ClientOID))
+	}
+
+	if order.CustomerID != "" {
+		txn.ZRem(ctx, userOrdersKey(order.CustomerID), key)
+	}
+
+	if _, err := txn.Exec(ctx); err != nil {
 		return fmt.Errorf("failed to exec transaction: %w", err)
 	}
 
 	return nil
 }
 
-// Update modifies an existing order using SetXX.
-func (r *RedisRepo) Update(ctx context.Context, order model.Order) error {
+// ErrVersionConflict is returned by Update when order.Version doesn't match
+// the version currently stored, meaning another update raced ahead of it.
+var ErrVersionConflict = errors.New("order version conflict")
+
+// updateScript performs the whole Update read-modify-write as a single
+// EVAL so the version check and write are atomic on the server, avoiding
+// lost updates from two concurrent callers racing on the same order. It
+// also reconciles the clientoid mapping against stored.client_oid (the
+// value before this update), rather than just the new one: if ClientOID
+// changed or was cleared, the old mapping is deleted so it stops resolving
+// to an order that no longer claims it; if it changed to a value already
+// claimed by a different order, the update is rejected the same way Insert
+// rejects a conflicting ClientOID.
+//
+// KEYS[1] - the order key
+// ARGV[1] - the version read alongside the order (expected to still be current)
+// ARGV[2] - the new order JSON, with Version already incremented
+// ARGV[3] - the new clientoid mapping key, or "" if the order has no ClientOID
+var updateScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current == false then
+	return redis.error_reply("NOTEXIST")
+end
+
+local stored = cjson.decode(current)
+if stored.version ~= tonumber(ARGV[1]) then
+	return redis.error_reply("VERSIONCONFLICT")
+end
+
+local newClientOIDKey = ARGV[3]
+
+if newClientOIDKey ~= "" then
+	local existing = redis.call("GET", newClientOIDKey)
+	if existing and existing ~= KEYS[1] then
+		return redis.error_reply("CLIENTOIDEXISTS")
+	end
+end
+
+redis.call("SET", KEYS[1], ARGV[2])
+
+local oldClientOID = stored.client_oid
+if oldClientOID and oldClientOID ~= "" then
+	local oldClientOIDKey = "clientoid:" .. oldClientOID
+	if oldClientOIDKey ~= newClientOIDKey then
+		redis.call("DEL", oldClientOIDKey)
+	end
+end
+
+if newClientOIDKey ~= "" then
+	redis.call("SET", newClientOIDKey, KEYS[1])
+end
+
+return "OK"
+`)
+
+// Update modifies an existing order using a compare-and-swap on order.Version:
+// the stored version must match order.Version, after which the version is
+// incremented and the order (and its clientoid mapping, if any) written back.
+// The whole read-modify-write runs as a single Lua script so it's atomic on
+// the Redis server. On success it returns the order as stored, with its
+// Version incremented, so callers know the version to use for their next update.
+func (r *RedisRepo) Update(ctx context.Context, order model.Order) (model.Order, error) {
+	key := orderIDKey(order.OrderID)
+
+	expectedVersion := order.Version
+	order.Version++
+
 	data, err := json.Marshal(order)
 	if err != nil {
-		return fmt.Errorf("failed to encode: %w", err)
+		return model.Order{}, fmt.Errorf("failed to encode: %w", err)
 	}
 
-	key := orderIDKey(order.OrderID)
-
-	err = r.Client.SetXX(ctx, key, string(data), 0).Err()
-	if errors.Is(err, redis.Nil) {
-		return ErrNotExist
-	} else if err != nil {
-		return fmt.Errorf("set order: %w", err)
+	clientOIDTarget := ""
+	if order.ClientOID != "" {
+		clientOIDTarget = clientOIDKey(order.ClientOID)
 	}
 
-	return nil
+	err = updateScript.Run(ctx, r.Client, []string{key}, expectedVersion, string(data), clientOIDTarget).Err()
+	switch {
+	case err == nil:
+		return order, nil
+	case strings.Contains(err.Error(), "NOTEXIST"):
+		return model.Order{}, ErrNotExist
+	case strings.Contains(err.Error(), "VERSIONCONFLICT"):
+		return model.Order{}, ErrVersionConflict
+	case strings.Contains(err.Error(), "CLIENTOIDEXISTS"):
+		return model.Order{}, ErrClientOIDExists
+	default:
+		return model.Order{}, fmt.Errorf("failed to exec update script: %w", err)
+	}
 }
 
 // FindAllPage defines pagination parameters.
 type FindAllPage struct {
 	Size   uint // Maximum number of orders to return
 	Offset uint // Starting cursor for pagination
+
+	// Status, if set, restricts the listing to orders in that status
+	// (open_orders/filled_orders/cancelled_orders) instead of all orders.
+	Status model.OrderStatus
 }
 
 // FindResult contains paginated orders and next cursor.
@@ -130,8 +348,16 @@ type FindResult struct {
 }
 
 // FindAll retrieves paginated orders using SSCAN and MGET for efficiency.
+// When page.Status is set, it scans that status's set instead of the
+// all-orders set, so callers can list only open orders or audit historical
+// fills/cancellations.
 func (r *RedisRepo) FindAll(ctx context.Context, page FindAllPage) (FindResult, error) {
-	res := r.Client.SScan(ctx, "orders", uint64(page.Offset), "*", int64(page.Size))
+	setKey := "orders"
+	if page.Status != "" {
+		setKey = statusSetKey(page.Status)
+	}
+
+	res := r.Client.SScan(ctx, setKey, uint64(page.Offset), "*", int64(page.Size))
 
 	keys, cursor, err := res.Result()
 	if err != nil {
@@ -150,22 +376,275 @@ func (r *RedisRepo) FindAll(ctx context.Context, page FindAllPage) (FindResult,
 		return FindResult{}, fmt.Errorf("failed to get orders: %w", err)
 	}
 
-	orders := make([]model.Order, len(xs))
+	orders := make([]model.Order, 0, len(xs))
+
+	for _, x := range xs {
+		// A key can be removed between the SSCAN and the MGET above; MGET
+		// reports that as a nil entry rather than an error, so skip it.
+		if x == nil {
+			continue
+		}
+
+		var order model.Order
+		if err := json.Unmarshal([]byte(x.(string)), &order); err != nil {
+			return FindResult{}, fmt.Errorf("failed to decode order json: %w", err)
+		}
+
+		orders = append(orders, order)
+	}
+
+	return FindResult{
+		Orders: orders,
+		Cursor: cursor,
+	}, nil
+}
+
+// FindByIDs retrieves multiple orders in a single MGET round-trip, returning
+// the orders that were found and the subset of ids that were missing rather
+// than failing the whole lookup when some ids don't exist.
+func (r *RedisRepo) FindByIDs(ctx context.Context, ids []uint64) ([]model.Order, []uint64, error) {
+	if len(ids) == 0 {
+		return []model.Order{}, nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = orderIDKey(id)
+	}
+
+	xs, err := r.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get orders: %w", err)
+	}
+
+	orders := make([]model.Order, 0, len(xs))
+	var missing []uint64
 
 	for i, x := range xs {
-		x := x.(string)
+		if x == nil {
+			missing = append(missing, ids[i])
+			continue
+		}
+
 		var order model.Order
+		if err := json.Unmarshal([]byte(x.(string)), &order); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode order json: %w", err)
+		}
+
+		orders = append(orders, order)
+	}
+
+	return orders, missing, nil
+}
+
+// FindByUser retrieves a customer's orders newest-first, using the
+// user:{customerID}:orders sorted set for ordering and MGET to load the
+// order payloads. page.Offset/page.Size are a plain numeric cursor into
+// that sorted set.
+func (r *RedisRepo) FindByUser(ctx context.Context, customerID string, page FindAllPage) (FindResult, error) {
+	start := int64(page.Offset)
+	stop := start + int64(page.Size) - 1
+
+	keys, err := r.Client.ZRevRange(ctx, userOrdersKey(customerID), start, stop).Result()
+	if err != nil {
+		return FindResult{}, fmt.Errorf("failed to get user order ids: %w", err)
+	}
+
+	if len(keys) == 0 {
+		return FindResult{
+			Orders: []model.Order{},
+			Cursor: uint64(page.Offset),
+		}, nil
+	}
+
+	xs, err := r.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return FindResult{}, fmt.Errorf("failed to get orders: %w", err)
+	}
 
-		err := json.Unmarshal([]byte(x), &order)
-		if err != nil {
+	orders := make([]model.Order, 0, len(xs))
+	for _, x := range xs {
+		if x == nil {
+			continue
+		}
+
+		var order model.Order
+		if err := json.Unmarshal([]byte(x.(string)), &order); err != nil {
 			return FindResult{}, fmt.Errorf("failed to decode order json: %w", err)
 		}
 
-		orders[i] = order
+		orders = append(orders, order)
 	}
 
 	return FindResult{
 		Orders: orders,
-		Cursor: cursor,
+		Cursor: uint64(page.Offset) + uint64(len(keys)),
 	}, nil
 }
+
+// CancelOrdersForUser cancels every order belonging to a customer: it loads
+// the customer's order IDs, then deletes each one through DeleteByID so the
+// orders set, status set, clientoid mapping, and user sorted set are all
+// cleaned up exactly as they would be for a single cancellation. It returns
+// the IDs of the orders that were cancelled.
+//
+// Each order is deleted independently rather than as a single transaction,
+// so an error partway through (e.g. a dropped connection) leaves the orders
+// processed so far cancelled and the rest untouched. On error the returned
+// IDs are exactly the ones that were successfully cancelled before it, so
+// callers can tell which orders still need retrying.
+func (r *RedisRepo) CancelOrdersForUser(ctx context.Context, customerID string) ([]uint64, error) {
+	keys, err := r.Client.ZRange(ctx, userOrdersKey(customerID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user order ids: %w", err)
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	xs, err := r.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get orders: %w", err)
+	}
+
+	var ids []uint64
+
+	for _, x := range xs {
+		if x == nil {
+			continue
+		}
+
+		var ord model.Order
+		if err := json.Unmarshal([]byte(x.(string)), &ord); err != nil {
+			return ids, fmt.Errorf("failed to decode order json: %w", err)
+		}
+
+		if err := r.DeleteByID(ctx, ord.OrderID); err != nil && !errors.Is(err, ErrNotExist) {
+			return ids, fmt.Errorf("failed to delete order %d: %w", ord.OrderID, err)
+		}
+
+		ids = append(ids, ord.OrderID)
+	}
+
+	return ids, nil
+}
+
+// moveStatusScript rewrites an order's JSON and moves its key between status
+// sets as a single atomic operation, CAS'd on the version read alongside the
+// order. This gives status transitions (MarkFilled/MarkCancelled/
+// PartialFillCancel) the same version-bump/CAS discipline as Update, so a
+// client PATCH racing a fill or cancellation gets ErrVersionConflict instead
+// of silently clobbering it: the fill/cancel bumps Version, so a concurrent
+// Update still carrying the pre-fill version no longer matches.
+//
+// KEYS[1] - the order key
+// ARGV[1] - the version read alongside the order (expected to still be current)
+// ARGV[2] - the new order JSON, with Version already incremented
+// ARGV[3] - the status set the order was in before the transition
+// ARGV[4] - the status set the order belongs to after the transition
+var moveStatusScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current == false then
+	return redis.error_reply("NOTEXIST")
+end
+
+local stored = cjson.decode(current)
+if stored.version ~= tonumber(ARGV[1]) then
+	return redis.error_reply("VERSIONCONFLICT")
+end
+
+redis.call("SET", KEYS[1], ARGV[2])
+
+if ARGV[3] ~= ARGV[4] then
+	redis.call("SREM", ARGV[3], KEYS[1])
+	redis.call("SADD", ARGV[4], KEYS[1])
+end
+
+return "OK"
+`)
+
+// moveStatus loads the order, applies mutate, and atomically rewrites its
+// JSON payload while moving its key from the status set it was previously in
+// to the set for its new status. The write is CAS'd on the version just read,
+// and bumps it, so it can't silently race with a concurrent Update or another
+// status transition.
+func (r *RedisRepo) moveStatus(ctx context.Context, id uint64, mutate func(*model.Order)) error {
+	key := orderIDKey(id)
+
+	value, err := r.Client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return ErrNotExist
+	} else if err != nil {
+		return fmt.Errorf("failed to get order: %w", err)
+	}
+
+	var order model.Order
+	if err := json.Unmarshal([]byte(value), &order); err != nil {
+		return fmt.Errorf("failed to decode order json: %w", err)
+	}
+
+	expectedVersion := order.Version
+	fromSet := statusSetKey(order.Status)
+	mutate(&order)
+	order.Version++
+	toSet := statusSetKey(order.Status)
+
+	data, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("failed to encode order: %w", err)
+	}
+
+	err = moveStatusScript.Run(ctx, r.Client, []string{key}, expectedVersion, string(data), fromSet, toSet).Err()
+	switch {
+	case err == nil:
+		return nil
+	case strings.Contains(err.Error(), "NOTEXIST"):
+		return ErrNotExist
+	case strings.Contains(err.Error(), "VERSIONCONFLICT"):
+		return ErrVersionConflict
+	default:
+		return fmt.Errorf("failed to exec move status script: %w", err)
+	}
+}
+
+// MarkFilled marks an order as fully filled, recording filledQty and
+// FilledAt, and atomically moves it from open_orders to filled_orders.
+func (r *RedisRepo) MarkFilled(ctx context.Context, id uint64, filledQty uint) error {
+	return r.moveStatus(ctx, id, func(order *model.Order) {
+		now := time.Now()
+		order.Status = model.OrderStatusFilled
+		order.FilledQty = filledQty
+		order.FilledAt = &now
+	})
+}
+
+// MarkCancelled marks an order as cancelled, recording CancelledAt, and
+// atomically moves it from open_orders to cancelled_orders.
+func (r *RedisRepo) MarkCancelled(ctx context.Context, id uint64) error {
+	return r.moveStatus(ctx, id, func(order *model.Order) {
+		now := time.Now()
+		order.Status = model.OrderStatusCancelled
+		order.CancelledAt = &now
+	})
+}
+
+// ErrInvalidStatusTransition is returned when a status-changing operation
+// isn't valid for the order's current status.
+var ErrInvalidStatusTransition = errors.New("order status transition not allowed")
+
+// PartialFillCancel cancels the unfilled remainder of an order that has
+// already received a partial fill. Unlike MarkCancelled, it refuses to
+// cancel an order that hasn't been partially filled yet.
+func (r *RedisRepo) PartialFillCancel(ctx context.Context, id uint64) error {
+	order, err := r.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if order.Status != model.OrderStatusPartiallyFilled {
+		return ErrInvalidStatusTransition
+	}
+
+	return r.MarkCancelled(ctx, id)
+}
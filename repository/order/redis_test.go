@@ -0,0 +1,383 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sibelephant/orders-api/model"
+)
+
+// newTestRepo starts an in-memory Redis server and returns a RedisRepo backed
+// by it, closing the server when the test finishes.
+func newTestRepo(t *testing.T) *RedisRepo {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+
+	return &RedisRepo{
+		Client: redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+	}
+}
+
+func TestInsertRejectsDuplicateClientOID(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, model.Order{OrderID: 1, ClientOID: "retry-1"}); err != nil {
+		t.Fatalf("first insert: %v", err)
+	}
+
+	err := repo.Insert(ctx, model.Order{OrderID: 2, ClientOID: "retry-1"})
+	if !errors.Is(err, ErrClientOIDExists) {
+		t.Fatalf("want ErrClientOIDExists, got %v", err)
+	}
+
+	// The rejected insert must not have left order 2 behind anywhere.
+	if _, err := repo.FindByID(ctx, 2); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("order 2 should not exist, FindByID returned: %v", err)
+	}
+
+	res, err := repo.FindAll(ctx, FindAllPage{Size: 10})
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(res.Orders) != 1 {
+		t.Fatalf("want 1 order after rejected insert, got %d", len(res.Orders))
+	}
+}
+
+func TestFindByClientOID(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, model.Order{OrderID: 1, ClientOID: "retry-1"}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	got, err := repo.FindByClientOID(ctx, "retry-1")
+	if err != nil {
+		t.Fatalf("FindByClientOID: %v", err)
+	}
+	if got.OrderID != 1 {
+		t.Fatalf("want order 1, got %d", got.OrderID)
+	}
+
+	if _, err := repo.FindByClientOID(ctx, "missing"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("want ErrNotExist, got %v", err)
+	}
+}
+
+func TestFindByIDsPartialFailure(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	for _, id := range []uint64{1, 2} {
+		if err := repo.Insert(ctx, model.Order{OrderID: id}); err != nil {
+			t.Fatalf("insert %d: %v", id, err)
+		}
+	}
+
+	found, missing, err := repo.FindByIDs(ctx, []uint64{1, 2, 999})
+	if err != nil {
+		t.Fatalf("FindByIDs: %v", err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("want 2 found, got %d", len(found))
+	}
+	if len(missing) != 1 || missing[0] != 999 {
+		t.Fatalf("want [999] missing, got %v", missing)
+	}
+}
+
+func TestFindAllSkipsKeysRemovedBetweenScanAndGet(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, model.Order{OrderID: 1}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	// Simulate a key vanishing between the SSCAN and the MGET: add a member
+	// to the orders set whose backing key was never written.
+	if err := repo.Client.SAdd(ctx, "orders", orderIDKey(404)).Err(); err != nil {
+		t.Fatalf("sadd: %v", err)
+	}
+
+	res, err := repo.FindAll(ctx, FindAllPage{Size: 10})
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(res.Orders) != 1 {
+		t.Fatalf("want 1 order, got %d", len(res.Orders))
+	}
+}
+
+func TestFindByUserNewestFirstAndCancelOrdersForUser(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	base := time.Now()
+	for i := uint64(1); i <= 3; i++ {
+		createdAt := base.Add(time.Duration(i) * time.Second)
+		err := repo.Insert(ctx, model.Order{
+			OrderID:    i,
+			ClientOID:  orderClientOID(i),
+			CustomerID: "cust-9",
+			CreatedAt:  &createdAt,
+		})
+		if err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	res, err := repo.FindByUser(ctx, "cust-9", FindAllPage{Size: 10})
+	if err != nil {
+		t.Fatalf("FindByUser: %v", err)
+	}
+	if len(res.Orders) != 3 || res.Orders[0].OrderID != 3 || res.Orders[2].OrderID != 1 {
+		t.Fatalf("want orders newest-first [3 2 1], got %v", orderIDs(res.Orders))
+	}
+
+	cancelled, err := repo.CancelOrdersForUser(ctx, "cust-9")
+	if err != nil {
+		t.Fatalf("CancelOrdersForUser: %v", err)
+	}
+	if len(cancelled) != 3 {
+		t.Fatalf("want 3 cancelled ids, got %v", cancelled)
+	}
+
+	res, err = repo.FindByUser(ctx, "cust-9", FindAllPage{Size: 10})
+	if err != nil {
+		t.Fatalf("FindByUser after cancel: %v", err)
+	}
+	if len(res.Orders) != 0 {
+		t.Fatalf("want 0 orders after cancel, got %d", len(res.Orders))
+	}
+
+	// The bulk cancel must clean up the same secondary indices DeleteByID does.
+	open, err := repo.FindAll(ctx, FindAllPage{Size: 10, Status: model.OrderStatusOpen})
+	if err != nil {
+		t.Fatalf("FindAll open: %v", err)
+	}
+	if len(open.Orders) != 0 {
+		t.Fatalf("want 0 open orders after cancel, got %d", len(open.Orders))
+	}
+
+	for i := uint64(1); i <= 3; i++ {
+		if _, err := repo.FindByClientOID(ctx, orderClientOID(i)); !errors.Is(err, ErrNotExist) {
+			t.Fatalf("clientoid mapping for order %d should be gone, got: %v", i, err)
+		}
+	}
+}
+
+func TestCancelOrdersForUserReportsOrdersCancelledBeforeAFailure(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, model.Order{OrderID: 1, CustomerID: "cust-9"}); err != nil {
+		t.Fatalf("insert 1: %v", err)
+	}
+
+	// Inject a corrupt entry into the user's order index so the second MGET
+	// result fails to decode, simulating a mid-loop failure.
+	if err := repo.Client.ZAdd(ctx, userOrdersKey("cust-9"), redis.Z{
+		Score: 9999999999, Member: orderIDKey(2),
+	}).Err(); err != nil {
+		t.Fatalf("zadd: %v", err)
+	}
+	if err := repo.Client.Set(ctx, orderIDKey(2), "not json", 0).Err(); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	cancelled, err := repo.CancelOrdersForUser(ctx, "cust-9")
+	if err == nil {
+		t.Fatal("want an error decoding the corrupt order, got nil")
+	}
+	if len(cancelled) != 1 || cancelled[0] != 1 {
+		t.Fatalf("want [1] reported as cancelled before the failure, got %v", cancelled)
+	}
+
+	if _, err := repo.FindByID(ctx, 1); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("order 1 should have been cancelled despite the later failure, got: %v", err)
+	}
+}
+
+func TestMarkFilledAndMarkCancelledMoveStatusSetsAndBumpVersion(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, model.Order{OrderID: 1}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if err := repo.Insert(ctx, model.Order{OrderID: 2}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	if err := repo.MarkFilled(ctx, 1, 5); err != nil {
+		t.Fatalf("MarkFilled: %v", err)
+	}
+	if err := repo.MarkCancelled(ctx, 2); err != nil {
+		t.Fatalf("MarkCancelled: %v", err)
+	}
+
+	filled, err := repo.FindByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("FindByID 1: %v", err)
+	}
+	if filled.Status != model.OrderStatusFilled || filled.Version != 1 || filled.FilledAt == nil {
+		t.Fatalf("order 1 not marked filled correctly: %+v", filled)
+	}
+
+	cancelled, err := repo.FindByID(ctx, 2)
+	if err != nil {
+		t.Fatalf("FindByID 2: %v", err)
+	}
+	if cancelled.Status != model.OrderStatusCancelled || cancelled.Version != 1 || cancelled.CancelledAt == nil {
+		t.Fatalf("order 2 not marked cancelled correctly: %+v", cancelled)
+	}
+
+	openRes, _ := repo.FindAll(ctx, FindAllPage{Size: 10, Status: model.OrderStatusOpen})
+	filledRes, _ := repo.FindAll(ctx, FindAllPage{Size: 10, Status: model.OrderStatusFilled})
+	cancelledRes, _ := repo.FindAll(ctx, FindAllPage{Size: 10, Status: model.OrderStatusCancelled})
+	if len(openRes.Orders) != 0 || len(filledRes.Orders) != 1 || len(cancelledRes.Orders) != 1 {
+		t.Fatalf("unexpected status set membership: open=%d filled=%d cancelled=%d",
+			len(openRes.Orders), len(filledRes.Orders), len(cancelledRes.Orders))
+	}
+}
+
+func TestMarkFilledBumpsVersionSoStaleUpdateIsRejected(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, model.Order{OrderID: 1}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	staleView, err := repo.FindByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+
+	if err := repo.MarkFilled(ctx, 1, 5); err != nil {
+		t.Fatalf("MarkFilled: %v", err)
+	}
+
+	// A client that read the order before the fill and now tries to PATCH it
+	// with the version it saw must be rejected, not silently overwrite the fill.
+	_, err = repo.Update(ctx, staleView)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("want ErrVersionConflict, got %v", err)
+	}
+}
+
+func TestPartialFillCancelRequiresPartiallyFilledStatus(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, model.Order{OrderID: 1}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	if err := repo.PartialFillCancel(ctx, 1); !errors.Is(err, ErrInvalidStatusTransition) {
+		t.Fatalf("want ErrInvalidStatusTransition for an open order, got %v", err)
+	}
+}
+
+func TestUpdateCAS(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, model.Order{OrderID: 1}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	updated, err := repo.Update(ctx, model.Order{OrderID: 1, Version: 0})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if updated.Version != 1 {
+		t.Fatalf("want version 1 after update, got %d", updated.Version)
+	}
+
+	// Replaying the same (now stale) version must conflict rather than apply.
+	if _, err := repo.Update(ctx, model.Order{OrderID: 1, Version: 0}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("want ErrVersionConflict, got %v", err)
+	}
+
+	// The version just returned is accepted.
+	if _, err := repo.Update(ctx, model.Order{OrderID: 1, Version: updated.Version}); err != nil {
+		t.Fatalf("Update with fresh version: %v", err)
+	}
+}
+
+func TestUpdateReconcilesClientOIDMapping(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, model.Order{OrderID: 1, ClientOID: "old-oid"}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	if _, err := repo.Update(ctx, model.Order{OrderID: 1, Version: 0, ClientOID: "new-oid"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if _, err := repo.FindByClientOID(ctx, "old-oid"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("stale clientoid mapping should be gone, got: %v", err)
+	}
+
+	got, err := repo.FindByClientOID(ctx, "new-oid")
+	if err != nil {
+		t.Fatalf("FindByClientOID new-oid: %v", err)
+	}
+	if got.OrderID != 1 {
+		t.Fatalf("want order 1, got %d", got.OrderID)
+	}
+}
+
+func TestUpdateRejectsStealingAnotherOrdersClientOID(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, model.Order{OrderID: 1, ClientOID: "taken"}); err != nil {
+		t.Fatalf("insert 1: %v", err)
+	}
+	if err := repo.Insert(ctx, model.Order{OrderID: 2}); err != nil {
+		t.Fatalf("insert 2: %v", err)
+	}
+
+	_, err := repo.Update(ctx, model.Order{OrderID: 2, Version: 0, ClientOID: "taken"})
+	if !errors.Is(err, ErrClientOIDExists) {
+		t.Fatalf("want ErrClientOIDExists, got %v", err)
+	}
+}
+
+func orderClientOID(id uint64) string {
+	return "coid-" + itoa(id)
+}
+
+func orderIDs(orders []model.Order) []uint64 {
+	ids := make([]uint64, len(orders))
+	for i, o := range orders {
+		ids[i] = o.OrderID
+	}
+	return ids
+}
+
+func itoa(id uint64) string {
+	if id == 0 {
+		return "0"
+	}
+	var buf [20]byte
+	i := len(buf)
+	for id > 0 {
+		i--
+		buf[i] = byte('0' + id%10)
+		id /= 10
+	}
+	return string(buf[i:])
+}